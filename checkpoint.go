@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const checkpointFilename = ".s3-versions-to-git-state.json"
+const gitignoreFilename = ".gitignore"
+
+// ensureGitignore makes sure the converted repo's .gitignore excludes the
+// tool's own checkpoint state file and blob cache directory, so a plain
+// `git add -A` in the finished repo doesn't commit them alongside the
+// S3-derived history. Existing entries are left untouched.
+func ensureGitignore(repoPath string) error {
+	entries := []string{checkpointFilename, blobCacheDirName + "/"}
+
+	path := filepath.Join(repoPath, gitignoreFilename)
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	present := make(map[string]bool)
+	for _, line := range strings.Split(string(existing), "\n") {
+		present[strings.TrimSpace(line)] = true
+	}
+
+	var toAdd []string
+	for _, entry := range entries {
+		if !present[entry] {
+			toAdd = append(toAdd, entry)
+		}
+	}
+	if len(toAdd) == 0 {
+		return nil
+	}
+
+	content := string(existing)
+	if len(content) > 0 && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	content += strings.Join(toAdd, "\n") + "\n"
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+type checkpointData struct {
+	Completed  map[string]bool   `json:"completed"`
+	PathHashes map[string]string `json:"path_hashes"`
+}
+
+// Checkpoint tracks which bucket+key+versionId tuples have already been
+// committed, and the last content hash written at each repo path, so a
+// re-run of the tool can skip past work it already did.
+type Checkpoint struct {
+	path       string
+	mu         sync.Mutex
+	Completed  map[string]bool
+	PathHashes map[string]string
+}
+
+func checkpointID(bucket, key, versionId string) string {
+	return strings.Join([]string{bucket, key, versionId}, "::")
+}
+
+func loadCheckpoint(repoPath string) (*Checkpoint, error) {
+	checkpoint := &Checkpoint{
+		path:       filepath.Join(repoPath, checkpointFilename),
+		Completed:  make(map[string]bool),
+		PathHashes: make(map[string]string),
+	}
+	data, err := os.ReadFile(checkpoint.path)
+	if os.IsNotExist(err) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var parsed checkpointData
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, err
+	}
+	if parsed.Completed != nil {
+		checkpoint.Completed = parsed.Completed
+	}
+	if parsed.PathHashes != nil {
+		checkpoint.PathHashes = parsed.PathHashes
+	}
+	return checkpoint, nil
+}
+
+func (c *Checkpoint) IsDone(bucket, key, versionId string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.Completed[checkpointID(bucket, key, versionId)]
+}
+
+func (c *Checkpoint) MarkDone(bucket, key, versionId string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.Completed[checkpointID(bucket, key, versionId)] = true
+	return c.save()
+}
+
+// LastHash returns the content hash last written at a repo path, if any.
+func (c *Checkpoint) LastHash(path string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	hash, ok := c.PathHashes[path]
+	return hash, ok
+}
+
+func (c *Checkpoint) SetHash(path, hash string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.PathHashes[path] = hash
+	return c.save()
+}
+
+func (c *Checkpoint) ClearHash(path string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.PathHashes, path)
+	return c.save()
+}
+
+func (c *Checkpoint) save() error {
+	data, err := json.MarshalIndent(checkpointData{
+		Completed:  c.Completed,
+		PathHashes: c.PathHashes,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(c.path, data, 0644)
+}
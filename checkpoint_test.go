@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEnsureGitignoreAddsCheckpointAndCacheEntries(t *testing.T) {
+	repoPath := t.TempDir()
+
+	if err := ensureGitignore(repoPath); err != nil {
+		t.Fatalf("ensureGitignore: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(repoPath, gitignoreFilename))
+	if err != nil {
+		t.Fatalf("reading .gitignore: %v", err)
+	}
+	lines := strings.Split(string(data), "\n")
+	want := map[string]bool{checkpointFilename: false, blobCacheDirName + "/": false}
+	for _, line := range lines {
+		if _, ok := want[line]; ok {
+			want[line] = true
+		}
+	}
+	for entry, found := range want {
+		if !found {
+			t.Errorf(".gitignore missing entry %q", entry)
+		}
+	}
+}
+
+func TestEnsureGitignorePreservesExistingEntries(t *testing.T) {
+	repoPath := t.TempDir()
+	existing := "node_modules/\n"
+	if err := os.WriteFile(filepath.Join(repoPath, gitignoreFilename), []byte(existing), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ensureGitignore(repoPath); err != nil {
+		t.Fatalf("ensureGitignore: %v", err)
+	}
+	if err := ensureGitignore(repoPath); err != nil {
+		t.Fatalf("second ensureGitignore call: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(repoPath, gitignoreFilename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := string(data)
+	if !strings.Contains(content, "node_modules/") {
+		t.Error(".gitignore lost a pre-existing entry")
+	}
+	if strings.Count(content, checkpointFilename) != 1 {
+		t.Errorf("ensureGitignore duplicated the checkpoint entry across repeated calls: %q", content)
+	}
+}
+
+func TestCheckpointRoundTrip(t *testing.T) {
+	repoPath := t.TempDir()
+
+	checkpoint, err := loadCheckpoint(repoPath)
+	if err != nil {
+		t.Fatalf("loadCheckpoint: %v", err)
+	}
+	if checkpoint.IsDone("bucket", "key", "v1") {
+		t.Fatal("fresh checkpoint reports a version as already done")
+	}
+	if err := checkpoint.MarkDone("bucket", "key", "v1"); err != nil {
+		t.Fatalf("MarkDone: %v", err)
+	}
+	if err := checkpoint.SetHash("key", "sha256-abc"); err != nil {
+		t.Fatalf("SetHash: %v", err)
+	}
+
+	reloaded, err := loadCheckpoint(repoPath)
+	if err != nil {
+		t.Fatalf("reloading checkpoint: %v", err)
+	}
+	if !reloaded.IsDone("bucket", "key", "v1") {
+		t.Error("reloaded checkpoint forgot a completed version")
+	}
+	if hash, ok := reloaded.LastHash("key"); !ok || hash != "sha256-abc" {
+		t.Errorf("reloaded checkpoint LastHash = (%q, %v), want (%q, true)", hash, ok, "sha256-abc")
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const blobCacheDirName = ".s3-versions-to-git-cache"
+
+// blobCache is a simple content-addressable store, keyed by a content hash,
+// that lets identical bytes seen across keys or versions be reused instead
+// of downloaded and written again.
+type blobCache struct {
+	root string
+}
+
+func newBlobCache(repoPath string) *blobCache {
+	return &blobCache{root: filepath.Join(repoPath, blobCacheDirName)}
+}
+
+// blobPath returns where a cache key's blob is stored, sharded by the first
+// two characters of the actual content digest rather than the cache key's
+// "etag-"/"sha256-" prefix, so blobs spread across many subdirectories
+// instead of piling into just two ("et"/"sh").
+func (c *blobCache) blobPath(hash string) string {
+	digest := hash
+	if i := strings.IndexByte(hash, '-'); i >= 0 {
+		digest = hash[i+1:]
+	}
+	return filepath.Join(c.root, digest[:2], hash)
+}
+
+func (c *blobCache) Has(hash string) bool {
+	_, err := os.Stat(c.blobPath(hash))
+	return err == nil
+}
+
+// Materialize places a copy of the cached blob at destination, preferring a
+// hard link so large objects aren't duplicated on disk.
+func (c *blobCache) Materialize(hash, destination string) error {
+	if err := os.MkdirAll(filepath.Dir(destination), 0777); err != nil {
+		return err
+	}
+	if err := os.Link(c.blobPath(hash), destination); err == nil {
+		return nil
+	}
+	return copyFile(c.blobPath(hash), destination)
+}
+
+// Put adopts the file at path into the cache under hash, if not already present.
+func (c *blobCache) Put(hash, path string) error {
+	dst := c.blobPath(hash)
+	if _, err := os.Stat(dst); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0777); err != nil {
+		return err
+	}
+	return copyFile(path, dst)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// usableETagHash returns a cache key derived from the S3 ETag, when it's a
+// plain MD5 hex digest of the object's bytes. Multipart upload ETags (e.g.
+// "<hash>-<n>") aren't a hash of the full content, so they can't be trusted
+// as a content key.
+func usableETagHash(etag string) (string, bool) {
+	if len(etag) == 32 && !strings.Contains(etag, "-") {
+		return "etag-" + etag, true
+	}
+	return "", false
+}
+
+func sha256OfFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	hasher := sha256.New()
+	if _, err := io.Copy(hasher, f); err != nil {
+		return "", err
+	}
+	return "sha256-" + hex.EncodeToString(hasher.Sum(nil)), nil
+}
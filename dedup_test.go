@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBlobPathShardsOnContentDigestNotKeyPrefix(t *testing.T) {
+	cache := newBlobCache(t.TempDir())
+
+	etagPath := cache.blobPath("etag-abcdef0123456789")
+	sha256Path := cache.blobPath("sha256-abcdef0123456789")
+
+	etagShard := filepath.Base(filepath.Dir(etagPath))
+	sha256Shard := filepath.Base(filepath.Dir(sha256Path))
+
+	if etagShard != "ab" {
+		t.Errorf("etag blob sharded under %q, want %q", etagShard, "ab")
+	}
+	if sha256Shard != "ab" {
+		t.Errorf("sha256 blob sharded under %q, want %q", sha256Shard, "ab")
+	}
+	if etagShard == "et" || sha256Shard == "sh" {
+		t.Errorf("blob sharded on the cache-key prefix instead of the content digest")
+	}
+}
+
+func TestBlobCachePutHasMaterialize(t *testing.T) {
+	cache := newBlobCache(t.TempDir())
+
+	src := filepath.Join(t.TempDir(), "src.txt")
+	if err := os.WriteFile(src, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hash := "sha256-deadbeef"
+	if cache.Has(hash) {
+		t.Fatal("cache reports a blob present before Put")
+	}
+	if err := cache.Put(hash, src); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if !cache.Has(hash) {
+		t.Fatal("cache does not report the blob present after Put")
+	}
+
+	dst := filepath.Join(t.TempDir(), "nested", "dst.txt")
+	if err := cache.Materialize(hash, dst); err != nil {
+		t.Fatalf("Materialize: %v", err)
+	}
+	data, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(string(data)) != "hello" {
+		t.Errorf("materialized blob content = %q, want %q", data, "hello")
+	}
+}
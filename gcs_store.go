@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// GCSStore implements VersionedObjectStore against a Google Cloud Storage
+// bucket with object versioning enabled. GCS has no delete-marker concept:
+// a deleted object simply stops appearing as the live generation while its
+// prior generations remain listed when Versions is requested, so
+// IsDeleteMarker is never set here.
+type GCSStore struct {
+	client *storage.Client
+}
+
+func newGCSStore() (*GCSStore, error) {
+	client, err := storage.NewClient(context.TODO())
+	if err != nil {
+		log.Println("Couldn't create GCS client. Have you set up application default credentials?")
+		errorMessage(err)
+		return nil, err
+	}
+	return &GCSStore{client: client}, nil
+}
+
+func (g *GCSStore) ListObjects(bucket string) ([]S3Object, error) {
+	rawObjects := make([]S3Object, 0)
+	it := g.client.Bucket(bucket).Objects(context.TODO(), nil)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("Listing objects failed while querying: %s\n", bucket)
+			errorMessage(err)
+			return nil, err
+		}
+		rawObjects = append(rawObjects, S3Object{
+			Key:    attrs.Name,
+			Bucket: bucket,
+		})
+	}
+	return rawObjects, nil
+}
+
+func (g *GCSStore) ListVersions(bucket, key string) ([]S3VersionedObject, error) {
+	versions := make([]S3VersionedObject, 0)
+	it := g.client.Bucket(bucket).Objects(context.TODO(), &storage.Query{
+		Prefix:   key,
+		Versions: true,
+	})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			log.Printf("Listing versions failed while querying: %s/%s\n", bucket, key)
+			errorMessage(err)
+			return versions, err
+		}
+		if !isExactKey(attrs.Name, key) {
+			continue
+		}
+		versions = append(versions, S3VersionedObject{
+			Key:          attrs.Name,
+			Bucket:       bucket,
+			VersionId:    fmt.Sprintf("%d", attrs.Generation),
+			LastModified: attrs.Updated,
+		})
+	}
+	return versions, nil
+}
+
+// withMetadata is accepted to satisfy VersionedObjectStore but otherwise
+// unused: GCS has no tagging call to skip (see the Tags comment below).
+func (g *GCSStore) GetObjectVersion(bucket, key, versionId string, withMetadata bool) (io.ReadCloser, *ObjectMetadata, error) {
+	generation, err := strconv.ParseInt(versionId, 10, 64)
+	if err != nil {
+		log.Printf("Invalid GCS generation %q for %s/%s\n", versionId, bucket, key)
+		errorMessage(err)
+		return nil, nil, err
+	}
+	obj := g.client.Bucket(bucket).Object(key).Generation(generation)
+
+	attrs, err := obj.Attrs(context.TODO())
+	if err != nil {
+		log.Printf("Couldn't get object attributes for %v:%v@%v.\n", bucket, key, versionId)
+		errorMessage(err)
+		return nil, nil, err
+	}
+
+	reader, err := obj.NewReader(context.TODO())
+	if err != nil {
+		log.Printf("Couldn't get object %v:%v@%v.\n", bucket, key, versionId)
+		errorMessage(err)
+		return nil, nil, err
+	}
+
+	// GCS has no object-tagging concept equivalent to S3's, so Tags is
+	// always empty here.
+	metadata := &ObjectMetadata{
+		VersionId:    versionId,
+		ETag:         attrs.Etag,
+		ContentType:  attrs.ContentType,
+		StorageClass: attrs.StorageClass,
+		UserMetadata: attrs.Metadata,
+		Tags:         make(map[string]string),
+	}
+	return reader, metadata, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+)
+
+const sidecarSuffix = ".s3meta.json"
+
+type objectMetadataSidecar struct {
+	Bucket       string            `json:"bucket"`
+	Key          string            `json:"key"`
+	VersionId    string            `json:"version_id"`
+	ETag         string            `json:"etag"`
+	ContentType  string            `json:"content_type"`
+	StorageClass string            `json:"storage_class"`
+	Metadata     map[string]string `json:"metadata,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+}
+
+func writeMetadataSidecar(object S3VersionedObject, metadata *ObjectMetadata) error {
+	sidecar := objectMetadataSidecar{
+		Bucket:       object.Bucket,
+		Key:          object.Key,
+		VersionId:    metadata.VersionId,
+		ETag:         metadata.ETag,
+		ContentType:  metadata.ContentType,
+		StorageClass: metadata.StorageClass,
+		Metadata:     metadata.UserMetadata,
+		Tags:         metadata.Tags,
+	}
+	data, err := json.MarshalIndent(sidecar, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(object.toLocalPath()+sidecarSuffix, data, 0644)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func buildCommitMessage(commitDate time.Time, objects []S3VersionedObject, metadataByID map[string]*ObjectMetadata) string {
+	msg := fmt.Sprintf("Modification on %s\n", commitDate)
+	for _, object := range objects {
+		metadata, ok := metadataByID[checkpointID(object.Bucket, object.Key, object.VersionId)]
+		if !ok {
+			continue
+		}
+		msg += fmt.Sprintf("\n%s:\n", object.toBasenamePath())
+		msg += fmt.Sprintf("S3-Version-Id: %s\n", metadata.VersionId)
+		msg += fmt.Sprintf("S3-ETag: %s\n", metadata.ETag)
+		for _, k := range sortedKeys(metadata.Tags) {
+			msg += fmt.Sprintf("S3-Tag-%s: %s\n", k, metadata.Tags[k])
+		}
+		for _, k := range sortedKeys(metadata.UserMetadata) {
+			msg += fmt.Sprintf("S3-Meta-%s: %s\n", k, metadata.UserMetadata[k])
+		}
+	}
+	return msg
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+const perKeyBranchPrefix = "s3/"
+const defaultBranchName = "master"
+
+func perKeyBranchName(key string) plumbing.ReferenceName {
+	return plumbing.NewBranchReferenceName(perKeyBranchPrefix + key)
+}
+
+type keyBranchCommit struct {
+	Key          string
+	Hash         plumbing.Hash
+	LastModified time.Time
+}
+
+// replayPerKeyBranches implements the --layout=per-key-branches mode: every
+// S3 key gets its own branch that receives one commit per version in
+// timestamp order, and the starting branch periodically folds those
+// branches in with --no-ff merge commits at each unique timestamp, so
+// `git log -- <path>` on a single key shows its true version chain.
+func replayPerKeyBranches(versions []S3VersionedObject, store VersionedObjectStore, container gitContainer) error {
+	startBranch := plumbing.NewBranchReferenceName(defaultBranchName)
+	if head, err := container.Repository.Head(); err == nil {
+		startBranch = head.Name()
+	} else if err := createRootCommit(container); err != nil {
+		log.Println("Error creating an initial commit for a fresh repository")
+		errorMessage(err)
+		return err
+	}
+
+	byKey := make(map[string][]S3VersionedObject)
+	var keys []string
+	for _, version := range versions {
+		if _, seen := byKey[version.Key]; !seen {
+			keys = append(keys, version.Key)
+		}
+		byKey[version.Key] = append(byKey[version.Key], version)
+	}
+
+	var mergeEvents []keyBranchCommit
+	for _, key := range keys {
+		commits, err := commitKeyBranch(byKey[key], store, container, key, startBranch)
+		if err != nil {
+			log.Printf("Error building branch %s%s\n", perKeyBranchPrefix, key)
+			errorMessage(err)
+			return err
+		}
+		mergeEvents = append(mergeEvents, commits...)
+	}
+
+	sort.SliceStable(mergeEvents, func(i, j int) bool {
+		return mergeEvents[i].LastModified.Before(mergeEvents[j].LastModified)
+	})
+
+	if err := container.Tree.Checkout(&git.CheckoutOptions{Branch: startBranch}); err != nil {
+		log.Printf("Error checking out %s before merging per-key branches\n", startBranch)
+		errorMessage(err)
+		return err
+	}
+
+	for _, group := range groupMergeEventsByTimestamp(mergeEvents) {
+		if err := mergeKeyBranchGroup(container, startBranch, group); err != nil {
+			log.Printf("Error merging %d key branch commit(s) at %s into %s\n", len(group), group[0].LastModified, startBranch)
+			errorMessage(err)
+			return err
+		}
+	}
+	return nil
+}
+
+// groupMergeEventsByTimestamp folds consecutive merge events that share a
+// LastModified into a single group, mirroring the linear layout's grouping
+// of every key touched at the same timestamp into one commit. mergeEvents
+// must already be sorted by LastModified.
+func groupMergeEventsByTimestamp(mergeEvents []keyBranchCommit) [][]keyBranchCommit {
+	var groups [][]keyBranchCommit
+	for _, event := range mergeEvents {
+		if n := len(groups); n > 0 && groups[n-1][0].LastModified.Equal(event.LastModified) {
+			groups[n-1] = append(groups[n-1], event)
+			continue
+		}
+		groups = append(groups, []keyBranchCommit{event})
+	}
+	return groups
+}
+
+// createRootCommit gives a brand-new repository its first commit, on
+// whatever branch HEAD currently names. go-git's Checkout{Create: true},
+// used below to branch off into each key's own branch, needs an existing
+// HEAD to branch from, which a freshly git-init'd repository doesn't have
+// until something has been committed to it.
+func createRootCommit(container gitContainer) error {
+	_, err := container.Tree.Commit("Initialize repository", &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  "s3-versions-to-git",
+			Email: "ahughesalex@gmail.com",
+			When:  time.Now(),
+		},
+		AllowEmptyCommits: true,
+	})
+	return err
+}
+
+// commitKeyBranch checks out (creating if necessary) the branch for a
+// single S3 key and commits each of its versions in order, one commit per
+// version, reusing applyGitChanges so downloads, checkpointing, dedup, and
+// metadata trailers all behave the same as the linear layout. Branch
+// switches deliberately avoid Force: true — go-git's hard reset sweeps
+// every untracked path in the worktree, including the checkpoint state file
+// and blob cache, while a plain merge-reset only touches tracked files.
+func commitKeyBranch(versions []S3VersionedObject, store VersionedObjectStore, container gitContainer, key string, baseBranch plumbing.ReferenceName) ([]keyBranchCommit, error) {
+	branch := perKeyBranchName(key)
+	_, err := container.Repository.Reference(branch, true)
+	checkoutOpts := &git.CheckoutOptions{Branch: branch}
+	if err != nil {
+		checkoutOpts.Create = true
+	}
+	if err := container.Tree.Checkout(checkoutOpts); err != nil {
+		return nil, err
+	}
+
+	commits := make([]keyBranchCommit, 0, len(versions))
+	for _, version := range versions {
+		if err := applyGitChanges([]S3VersionedObject{version}, store, container); err != nil {
+			return nil, err
+		}
+		head, err := container.Repository.Reference(branch, true)
+		if err != nil {
+			return nil, err
+		}
+		commits = append(commits, keyBranchCommit{
+			Key:          key,
+			Hash:         head.Hash(),
+			LastModified: version.LastModified,
+		})
+	}
+
+	if err := container.Tree.Checkout(&git.CheckoutOptions{Branch: baseBranch}); err != nil {
+		return nil, err
+	}
+	return commits, nil
+}
+
+// mergeKeyBranchGroup folds every key branch commit sharing a single
+// timestamp into one --no-ff merge commit: each key's file is copied into
+// the main worktree and staged, a single commit is made for the group, and
+// a parent hash per key branch tip is then grafted onto it so every join is
+// visible in history.
+func mergeKeyBranchGroup(container gitContainer, mainBranch plumbing.ReferenceName, group []keyBranchCommit) error {
+	if err := container.Tree.Checkout(&git.CheckoutOptions{Branch: mainBranch}); err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(group))
+	for _, event := range group {
+		keyCommit, err := container.Repository.CommitObject(event.Hash)
+		if err != nil {
+			return err
+		}
+		keyTree, err := keyCommit.Tree()
+		if err != nil {
+			return err
+		}
+		if err := keyTree.Files().ForEach(func(f *object.File) error {
+			return copyTreeFileToWorktree(container, f)
+		}); err != nil {
+			return err
+		}
+		keys = append(keys, perKeyBranchPrefix+event.Key)
+	}
+	if _, err := container.Tree.Add("."); err != nil {
+		return err
+	}
+
+	timestamp := group[0].LastModified
+	msg := fmt.Sprintf("Merge %s at %s", strings.Join(keys, ", "), timestamp)
+	hash, err := container.Tree.Commit(msg, &git.CommitOptions{
+		Author: &object.Signature{
+			Name:  "s3-versions-to-git",
+			Email: "ahughesalex@gmail.com",
+			When:  timestamp,
+		},
+		AllowEmptyCommits: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	mergeCommit, err := container.Repository.CommitObject(hash)
+	if err != nil {
+		return err
+	}
+	for _, event := range group {
+		mergeCommit.ParentHashes = append(mergeCommit.ParentHashes, event.Hash)
+	}
+	obj := container.Repository.Storer.NewEncodedObject()
+	if err := mergeCommit.Encode(obj); err != nil {
+		return err
+	}
+	newHash, err := container.Repository.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return err
+	}
+	// Grafting the extra parent hashes doesn't change the commit's tree, so
+	// the worktree and index populated by Commit above already match
+	// newHash; no further Reset against the worktree is needed (a hard
+	// reset here would also sweep untracked state like the checkpoint file
+	// and blob cache out of the repo root).
+	return container.Repository.Storer.SetReference(plumbing.NewHashReference(mainBranch, newHash))
+}
+
+func copyTreeFileToWorktree(container gitContainer, f *object.File) error {
+	reader, err := f.Reader()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	destination := filepath.Join(container.Tree.Filesystem.Root(), f.Name)
+	if err := os.MkdirAll(filepath.Dir(destination), 0777); err != nil {
+		return err
+	}
+	out, err := os.Create(destination)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, reader)
+	return err
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestReplayPerKeyBranchesOnFreshRepoPreservesCheckpointState(t *testing.T) {
+	const bucket = "bucket"
+	container, repositoryRoot := newTestContainer(t, bucket, false)
+	repoPath := filepath.Join(repositoryRoot, bucket)
+
+	checkpoint, err := loadCheckpoint(repoPath)
+	if err != nil {
+		t.Fatalf("loadCheckpoint: %v", err)
+	}
+	container.Checkpoint = checkpoint
+
+	store := newFakeStore()
+	store.put(bucket, "a.txt", "v1", []byte("a1"))
+	store.put(bucket, "b.txt", "v1", []byte("b1"))
+
+	timestamp := time.Now()
+	versions := []S3VersionedObject{
+		{Key: "a.txt", Bucket: bucket, VersionId: "v1", RepositoryRoot: repositoryRoot, LastModified: timestamp},
+		{Key: "b.txt", Bucket: bucket, VersionId: "v1", RepositoryRoot: repositoryRoot, LastModified: timestamp},
+	}
+
+	// A fresh repo has no HEAD yet; this used to fail with "reference not
+	// found" the moment the first key tried to branch off.
+	if err := replayPerKeyBranches(versions, store, container); err != nil {
+		t.Fatalf("replayPerKeyBranches on a fresh repo: %v", err)
+	}
+
+	if _, err := os.Stat(filepath.Join(repoPath, checkpointFilename)); err != nil {
+		t.Errorf("checkpoint state file missing after replayPerKeyBranches: %v", err)
+	}
+
+	head, err := container.Repository.Head()
+	if err != nil {
+		t.Fatalf("Head: %v", err)
+	}
+	commit, err := container.Repository.CommitObject(head.Hash())
+	if err != nil {
+		t.Fatalf("CommitObject: %v", err)
+	}
+	// A --no-ff merge keeps the previous mainline tip as a parent alongside
+	// each branch being merged, so the root commit this replay started from
+	// plus the two key branch tips makes 3 parents here, not 2.
+	if len(commit.ParentHashes) != 3 {
+		t.Errorf("expected the merge commit to keep the prior mainline tip plus both key branch tips as parents, got %d", len(commit.ParentHashes))
+	}
+}
+
+// TestReplayPerKeyBranchesAcrossMultipleGroupsKeepsEarlierMergesReachable
+// guards against a regression where grafting a merge group's branch tip
+// parents onto the commit Tree.Commit just made replaced its implicit first
+// parent (the previous mainline tip) instead of joining it: every merge
+// group after the first would then detach all earlier merges from main's
+// ancestry, so `git log -- <path>` on a key only merged in an earlier group
+// would come up empty even though its commit still exists in the repo.
+func TestReplayPerKeyBranchesAcrossMultipleGroupsKeepsEarlierMergesReachable(t *testing.T) {
+	const bucket = "bucket"
+	container, repositoryRoot := newTestContainer(t, bucket, false)
+
+	store := newFakeStore()
+	store.put(bucket, "a.txt", "v1", []byte("a1"))
+	store.put(bucket, "b.txt", "v1", []byte("b1"))
+	store.put(bucket, "a.txt", "v2", []byte("a2"))
+
+	t1 := time.Now()
+	t2 := t1.Add(time.Minute)
+	versions := []S3VersionedObject{
+		{Key: "a.txt", Bucket: bucket, VersionId: "v1", RepositoryRoot: repositoryRoot, LastModified: t1},
+		{Key: "b.txt", Bucket: bucket, VersionId: "v1", RepositoryRoot: repositoryRoot, LastModified: t1},
+		{Key: "a.txt", Bucket: bucket, VersionId: "v2", RepositoryRoot: repositoryRoot, LastModified: t2},
+	}
+
+	if err := replayPerKeyBranches(versions, store, container); err != nil {
+		t.Fatalf("replayPerKeyBranches across multiple timestamp groups: %v", err)
+	}
+
+	firstMerge, err := container.Repository.Reference(plumbing.NewBranchReferenceName(perKeyBranchPrefix+"b.txt"), true)
+	if err != nil {
+		t.Fatalf("Reference for b.txt branch: %v", err)
+	}
+
+	head, err := container.Repository.Head()
+	if err != nil {
+		t.Fatalf("Head: %v", err)
+	}
+
+	log, err := container.Repository.Log(&git.LogOptions{From: head.Hash()})
+	if err != nil {
+		t.Fatalf("Log: %v", err)
+	}
+	defer log.Close()
+
+	var foundBranch bool
+	if err := log.ForEach(func(c *object.Commit) error {
+		if c.Hash == firstMerge.Hash() {
+			foundBranch = true
+		}
+		return nil
+	}); err != nil {
+		t.Fatalf("walking commit ancestry from HEAD: %v", err)
+	}
+
+	if !foundBranch {
+		t.Errorf("expected b.txt's key branch commit (only merged in the first, earlier timestamp group) to still be an ancestor of HEAD after a later merge group ran")
+	}
+}
+
+func TestGroupMergeEventsByTimestamp(t *testing.T) {
+	t1 := time.Now()
+	t2 := t1.Add(time.Minute)
+	events := []keyBranchCommit{
+		{Key: "a.txt", LastModified: t1},
+		{Key: "b.txt", LastModified: t1},
+		{Key: "c.txt", LastModified: t2},
+	}
+
+	groups := groupMergeEventsByTimestamp(events)
+	if len(groups) != 2 {
+		t.Fatalf("expected 2 groups, got %d", len(groups))
+	}
+	if len(groups[0]) != 2 {
+		t.Errorf("expected the first group to contain both same-timestamp events, got %d", len(groups[0]))
+	}
+	if len(groups[1]) != 1 {
+		t.Errorf("expected the second group to contain the later event alone, got %d", len(groups[1]))
+	}
+}
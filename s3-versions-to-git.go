@@ -1,13 +1,10 @@
 package main
 
 import (
-	"context"
 	"flag"
 	"fmt"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/fatih/color"
+	"github.com/go-git/go-git/v5/plumbing/format/index"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"io"
 	"log"
@@ -15,13 +12,18 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 import "github.com/go-git/go-git/v5"
 
 type gitContainer struct {
-	Tree       *git.Worktree
-	Repository *git.Repository
+	Tree        *git.Worktree
+	Repository  *git.Repository
+	Concurrency int
+	Checkpoint  *Checkpoint
+	Metadata    bool
+	Cache       *blobCache
 }
 
 type S3Object struct {
@@ -35,6 +37,7 @@ type S3VersionedObject struct {
 	VersionId      string
 	RepositoryRoot string
 	LastModified   time.Time
+	IsDeleteMarker bool
 }
 
 func (svo *S3VersionedObject) toLocalPath() string {
@@ -62,75 +65,50 @@ func errorMessage(message any) {
 func help() {
 	helpMessage := "s3 Versions To Git History\n" +
 		"\n" +
-		"s3-versions-to-git-history --bucket=<s3Bucket> [--output=<outputDir>] [--profile=<awsProfile>] [--region=us-west-2]\n" +
+		"s3-versions-to-git-history --bucket=<s3Bucket> [--output=<outputDir>] [--profile=<awsProfile>] [--region=us-west-2] [--concurrency=4] [--backend=s3|s3-compat|gcs] [--endpoint=<url>] [--force-path-style] [--no-metadata] [--layout=linear|per-key-branches]\n" +
 		"\n" +
 		"\t--bucket\t\tThe S3 bucket you'd like to turn into a git repo.\n" +
 		"\t--output\t\tThe output directory to create a git repo in. Defaults to pwd if not provided.\n" +
 		"\t--profile\t\tThe AWS Profile you'd like to use. Defaults to the \"default\" profile if not provided.\n" +
 		"\t--region\t\tThe AWS Region you'd like to use. Defaults to us-west-2 if not provided.\n" +
+		"\t--concurrency\t\tNumber of object versions to download in parallel within a single commit. Defaults to 4.\n" +
+		"\t--backend\t\tThe object store backend to convert: s3, s3-compat, or gcs. Defaults to s3.\n" +
+		"\t--endpoint\t\tCustom endpoint URL, for s3-compat backends like MinIO or Ceph.\n" +
+		"\t--force-path-style\tUse path-style addressing, required by most s3-compat backends.\n" +
+		"\t--no-metadata\t\tSkip preserving S3 metadata, tags, and storage class as commit trailers and .s3meta.json sidecars.\n" +
+		"\t--layout\t\tHistory layout: linear groups every key sharing a timestamp into one commit (default); per-key-branches gives each key its own s3/<key> branch with one commit per version, --no-ff merged into the main branch at each timestamp.\n" +
 		"\n"
 	fmt.Printf(helpMessage)
 }
 
-func getS3Client(profile, region string) (*s3.Client, error) {
-	var sdkConfig aws.Config
-	var err error
-	if profile != "" {
-		sdkConfig, err = config.LoadDefaultConfig(context.TODO(), config.WithSharedConfigProfile(profile), config.WithRegion(region))
-	} else {
-		sdkConfig, err = config.LoadDefaultConfig(context.TODO())
-	}
-
-	if err != nil {
-		log.Println("Couldn't load default configuration. Have you set up your AWS account?")
+func newObjectStore(backend, profile, region, endpoint string, forcePathStyle bool) (VersionedObjectStore, error) {
+	switch backend {
+	case "s3", "s3-compat":
+		return newS3Store(profile, region, endpoint, forcePathStyle)
+	case "gcs":
+		return newGCSStore()
+	default:
+		err := fmt.Errorf("unsupported backend %q, expected one of: s3, s3-compat, gcs", backend)
 		errorMessage(err)
 		return nil, err
 	}
-	s3Client := s3.NewFromConfig(sdkConfig)
-	return s3Client, nil
 }
 
-func queryS3Bucket(bucketName string, s3Client *s3.Client) ([]S3Object, error) {
-	rawObjects := make([]S3Object, 0)
-
-	result, err := s3Client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
-		Bucket: aws.String(bucketName),
-	})
-	if err != nil {
-		log.Printf("ListObjectsV2 failed while querying: %s\n", bucketName)
-		errorMessage(err)
-		return nil, err
-	}
-	contents := result.Contents
-	for _, s3obj := range contents {
-		rawObjects = append(rawObjects, S3Object{
-			Key:    aws.ToString(s3obj.Key),
-			Bucket: bucketName,
-		})
-	}
-	return rawObjects, nil
+func queryS3Bucket(bucketName string, store VersionedObjectStore) ([]S3Object, error) {
+	return store.ListObjects(bucketName)
 }
 
-func queryS3Versions(objects []S3Object, repoPath string, s3Client *s3.Client) ([]S3VersionedObject, error) {
+func queryS3Versions(objects []S3Object, repoPath string, store VersionedObjectStore) ([]S3VersionedObject, error) {
 	s3Objects := make([]S3VersionedObject, 0)
 	for _, s3obj := range objects {
-		result, err := s3Client.ListObjectVersions(context.TODO(), &s3.ListObjectVersionsInput{
-			Bucket: aws.String(s3obj.Bucket),
-			Prefix: aws.String(s3obj.Key),
-		})
+		versions, err := store.ListVersions(s3obj.Bucket, s3obj.Key)
 		if err != nil {
-			log.Println("Couldn't load default configuration. Have you set up your AWS account?")
 			errorMessage(err)
 			continue
 		}
-		for _, vers := range result.Versions {
-			s3Objects = append(s3Objects, S3VersionedObject{
-				Key:            aws.ToString(vers.Key),
-				Bucket:         s3obj.Bucket,
-				VersionId:      aws.ToString(vers.VersionId),
-				LastModified:   aws.ToTime(vers.LastModified),
-				RepositoryRoot: repoPath,
-			})
+		for _, version := range versions {
+			version.RepositoryRoot = repoPath
+			s3Objects = append(s3Objects, version)
 		}
 	}
 	sort.Slice(s3Objects, func(i, j int) bool {
@@ -139,7 +117,7 @@ func queryS3Versions(objects []S3Object, repoPath string, s3Client *s3.Client) (
 	return s3Objects, nil
 }
 
-func replayS3Changes(versions []S3VersionedObject, s3Client *s3.Client, container gitContainer) {
+func replayS3Changes(versions []S3VersionedObject, store VersionedObjectStore, container gitContainer) {
 	var currentDate time.Time
 	objectModifications := make([]S3VersionedObject, 0)
 	for _, version := range versions {
@@ -148,7 +126,7 @@ func replayS3Changes(versions []S3VersionedObject, s3Client *s3.Client, containe
 			currentDate = version.LastModified
 		}
 		if version.LastModified.After(currentDate) {
-			err := applyGitChanges(objectModifications, s3Client, container)
+			err := applyGitChanges(objectModifications, store, container)
 			if err != nil {
 				log.Printf("Error applying Git changes for %d objects\n", len(objectModifications))
 				errorMessage(err)
@@ -162,71 +140,224 @@ func replayS3Changes(versions []S3VersionedObject, s3Client *s3.Client, containe
 	}
 }
 
-func downloadFile(object S3VersionedObject, s3Client *s3.Client) error {
-	result, err := s3Client.GetObject(context.TODO(), &s3.GetObjectInput{
-		Bucket:    aws.String(object.Bucket),
-		Key:       aws.String(object.Key),
-		VersionId: aws.String(object.VersionId),
-	})
+const downloadBufferSize = 1 << 20 // 1 MiB, bounds memory use for large objects
+
+// downloadFile streams a version's bytes to disk, reusing an already-cached
+// blob by content hash when available instead of transferring it again.
+// It returns the object's metadata (nil when withMetadata is false) and the
+// content hash the downloaded (or reused) bytes were stored under.
+func downloadFile(object S3VersionedObject, store VersionedObjectStore, withMetadata bool, cache *blobCache) (*ObjectMetadata, string, error) {
+	body, metadata, err := store.GetObjectVersion(object.Bucket, object.Key, object.VersionId, withMetadata)
 	if err != nil {
-		log.Printf("Couldn't get object %v:%v@%v.\n", object.Bucket, object.Key, object.VersionId)
-		errorMessage(err)
-		return err
+		return nil, "", err
 	}
-	defer result.Body.Close()
+	defer body.Close()
+
 	filename := object.toLocalPath()
 	dirname := filepath.Dir(filename)
-	_, err = os.Stat(dirname)
-	if os.IsNotExist(err) {
-		err = os.MkdirAll(dirname, 0777)
+	if _, statErr := os.Stat(dirname); os.IsNotExist(statErr) {
+		if err := os.MkdirAll(dirname, 0777); err != nil {
+			log.Printf("Couldn't create file %v.\n", filename)
+			errorMessage(err)
+			return nil, "", err
+		}
+	}
+
+	var hash string
+	if etagHash, ok := usableETagHash(metadata.ETag); ok && cache.Has(etagHash) {
+		if err := cache.Materialize(etagHash, filename); err != nil {
+			log.Printf("Couldn't reuse cached blob for %v.\n", filename)
+			errorMessage(err)
+			return nil, "", err
+		}
+		hash = etagHash
+	} else {
+		file, err := os.Create(filename)
 		if err != nil {
 			log.Printf("Couldn't create file %v.\n", filename)
 			errorMessage(err)
-			return err
+			return nil, "", err
+		}
+		_, copyErr := io.CopyBuffer(file, body, make([]byte, downloadBufferSize))
+		closeErr := file.Close()
+		if copyErr != nil {
+			log.Printf("Couldn't read object body from %v@%v.\n", object.Key, object.VersionId)
+			errorMessage(copyErr)
+			return nil, "", copyErr
+		}
+		if closeErr != nil {
+			return nil, "", closeErr
+		}
+
+		if etagHash, ok := usableETagHash(metadata.ETag); ok {
+			hash = etagHash
+		} else if sum, err := sha256OfFile(filename); err == nil {
+			hash = sum
+		}
+		if hash != "" {
+			if err := cache.Put(hash, filename); err != nil {
+				log.Printf("Couldn't cache blob for %v.\n", filename)
+				errorMessage(err)
+			}
 		}
 	}
-	file, err := os.Create(filename)
-	if err != nil {
-		log.Printf("Couldn't create file %v.\n", filename)
-		errorMessage(err)
-		return err
+
+	if !withMetadata {
+		return nil, hash, nil
 	}
-	defer file.Close()
-	body, err := io.ReadAll(result.Body)
-	if err != nil {
-		log.Printf("Couldn't read object body from %v@%v.\n", object.Key, object.VersionId)
+	if err := writeMetadataSidecar(object, metadata); err != nil {
+		log.Printf("Error writing metadata sidecar for %v\n", filename)
 		errorMessage(err)
 	}
-	_, err = file.Write(body)
-	return err
+	return metadata, hash, nil
+}
+
+type downloadOutcome struct {
+	Metadata *ObjectMetadata
+	Hash     string
+}
+
+func downloadPending(pending []S3VersionedObject, store VersionedObjectStore, concurrency int, withMetadata bool, cache *blobCache) (map[string]downloadOutcome, error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var firstErr error
+	outcomes := make(map[string]downloadOutcome)
+
+	for _, version := range pending {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(object S3VersionedObject) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			metadata, hash, err := downloadFile(object, store, withMetadata, cache)
+			if err != nil {
+				log.Printf("Error downloading object %s:%s@%s to %s\n", object.Bucket, object.Key, object.VersionId, object.toLocalPath())
+				errorMessage(err)
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+				return
+			}
+			mu.Lock()
+			outcomes[checkpointID(object.Bucket, object.Key, object.VersionId)] = downloadOutcome{Metadata: metadata, Hash: hash}
+			mu.Unlock()
+		}(version)
+	}
+	wg.Wait()
+	return outcomes, firstErr
 }
 
-func applyGitChanges(objects []S3VersionedObject, s3Client *s3.Client, container gitContainer) error {
+func applyGitChanges(objects []S3VersionedObject, store VersionedObjectStore, container gitContainer) error {
 	var commitDate time.Time
 	files := make([]string, 0)
-	for _, object := range objects {
-		err := downloadFile(object, s3Client)
-		if err != nil {
-			log.Printf("Error downloading object %s:%s@%s to %s\n", object.Bucket, object.Key, object.VersionId, object.toLocalPath())
-			errorMessage(err)
-			return err
+
+	pending := make([]S3VersionedObject, 0, len(objects))
+	for _, version := range objects {
+		if container.Checkpoint != nil && container.Checkpoint.IsDone(version.Bucket, version.Key, version.VersionId) {
+			continue
+		}
+		pending = append(pending, version)
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	toDownload := make([]S3VersionedObject, 0, len(pending))
+	for _, version := range pending {
+		if !version.IsDeleteMarker {
+			toDownload = append(toDownload, version)
+		}
+	}
+	outcomes, err := downloadPending(toDownload, store, container.Concurrency, container.Metadata, container.Cache)
+	if err != nil {
+		return err
+	}
+
+	metadataByID := make(map[string]*ObjectMetadata)
+	toStage := make([]S3VersionedObject, 0, len(pending))
+	for _, object := range pending {
+		if object.IsDeleteMarker {
+			toStage = append(toStage, object)
+			continue
 		}
+		outcome := outcomes[checkpointID(object.Bucket, object.Key, object.VersionId)]
+		if outcome.Metadata != nil {
+			metadataByID[checkpointID(object.Bucket, object.Key, object.VersionId)] = outcome.Metadata
+		}
+		if container.Checkpoint != nil && outcome.Hash != "" {
+			if lastHash, ok := container.Checkpoint.LastHash(object.toBasenamePath()); ok && lastHash == outcome.Hash {
+				continue
+			}
+		}
+		toStage = append(toStage, object)
+	}
+
+	if len(toStage) == 0 {
+		if container.Checkpoint != nil {
+			for _, object := range pending {
+				if err := container.Checkpoint.MarkDone(object.Bucket, object.Key, object.VersionId); err != nil {
+					log.Printf("Error persisting checkpoint state for %s@%s\n", object.Key, object.VersionId)
+					errorMessage(err)
+				}
+			}
+		}
+		return nil
+	}
+
+	for _, object := range toStage {
 		files = append(files, object.toLocalPath())
 		commitDate = object.LastModified
-		_, err = container.Tree.Add(object.toBasenamePath())
-		if err != nil {
+		if object.IsDeleteMarker {
+			if _, err := container.Tree.Remove(object.toBasenamePath()); err != nil {
+				if err != index.ErrEntryNotFound {
+					log.Printf("Error removing object %s\n", object.toLocalPath())
+					errorMessage(err)
+					return err
+				}
+				log.Printf("Delete marker for %s has no tracked file to remove, skipping\n", object.toBasenamePath())
+			}
+			if container.Metadata {
+				if _, err := container.Tree.Remove(object.toBasenamePath() + sidecarSuffix); err != nil && err != index.ErrEntryNotFound {
+					log.Printf("Error removing metadata sidecar for %s\n", object.toLocalPath())
+					errorMessage(err)
+					return err
+				}
+			}
+			if container.Checkpoint != nil {
+				if err := container.Checkpoint.ClearHash(object.toBasenamePath()); err != nil {
+					log.Printf("Error clearing checkpoint hash for %s\n", object.toBasenamePath())
+					errorMessage(err)
+				}
+			}
+			continue
+		}
+		if _, err := container.Tree.Add(object.toBasenamePath()); err != nil {
 			log.Printf("Error staging object %s\n", object.toLocalPath())
 			errorMessage(err)
 			return err
 		}
+		if container.Metadata {
+			if _, err := container.Tree.Add(object.toBasenamePath() + sidecarSuffix); err != nil {
+				log.Printf("Error staging metadata sidecar for %s\n", object.toLocalPath())
+				errorMessage(err)
+				return err
+			}
+		}
 	}
-	commitMsg := fmt.Sprintf("Modification on %s", commitDate)
+	commitMsg := buildCommitMessage(commitDate, toStage, metadataByID)
 	commit, err := container.Tree.Commit(commitMsg, &git.CommitOptions{
 		Author: &object.Signature{
 			Name:  "s3-versions-to-git",
 			Email: "ahughesalex@gmail.com",
 			When:  commitDate,
 		},
+		AllowEmptyCommits: true,
 	})
 	if err != nil {
 		log.Printf("Error comitting objects to stage\n")
@@ -240,6 +371,26 @@ func applyGitChanges(objects []S3VersionedObject, s3Client *s3.Client, container
 		return err
 	}
 
+	if container.Checkpoint != nil {
+		for _, object := range pending {
+			if err := container.Checkpoint.MarkDone(object.Bucket, object.Key, object.VersionId); err != nil {
+				log.Printf("Error persisting checkpoint state for %s@%s\n", object.Key, object.VersionId)
+				errorMessage(err)
+			}
+		}
+		for _, object := range toStage {
+			if object.IsDeleteMarker {
+				continue
+			}
+			if hash := outcomes[checkpointID(object.Bucket, object.Key, object.VersionId)].Hash; hash != "" {
+				if err := container.Checkpoint.SetHash(object.toBasenamePath(), hash); err != nil {
+					log.Printf("Error persisting checkpoint hash for %s\n", object.toBasenamePath())
+					errorMessage(err)
+				}
+			}
+		}
+	}
+
 	log.Printf("Successfully applied commit with the following files:\n%s\n\t", strings.Join(files, "\n\t"))
 	return nil
 }
@@ -249,6 +400,12 @@ func main() {
 	outputFlag := flag.String("output", "", "Output directory to create git repo in")
 	profileFlag := flag.String("profile", "", "The AWS Profile you'd like to use")
 	regionFlag := flag.String("region", "us-west-2", "The AWS Region you'd like to use")
+	concurrencyFlag := flag.Int("concurrency", 4, "Number of versions to download in parallel within a single commit")
+	backendFlag := flag.String("backend", "s3", "The object store backend to convert: s3, s3-compat, or gcs")
+	endpointFlag := flag.String("endpoint", "", "Custom endpoint URL, for s3-compat backends like MinIO or Ceph")
+	forcePathStyleFlag := flag.Bool("force-path-style", false, "Use path-style addressing, required by most s3-compat backends")
+	noMetadataFlag := flag.Bool("no-metadata", false, "Skip preserving S3 metadata, tags, and storage class as commit trailers and .s3meta.json sidecars")
+	layoutFlag := flag.String("layout", "linear", "History layout: linear or per-key-branches")
 	helpFlag := flag.Bool("help", false, "Program usage and help")
 	flag.Parse()
 	if *helpFlag == true {
@@ -295,21 +452,48 @@ func main() {
 		errorMessage(err)
 		return
 	}
+	if err := ensureGitignore(repoPath); err != nil {
+		log.Printf("Error unable to write .gitignore in %s\n", repoPath)
+		errorMessage(err)
+		return
+	}
+	checkpoint, err := loadCheckpoint(repoPath)
+	if err != nil {
+		log.Printf("Error unable to load checkpoint state from %s\n", repoPath)
+		errorMessage(err)
+		return
+	}
+
 	repo := gitContainer{
-		Tree:       worktree,
-		Repository: r,
+		Tree:        worktree,
+		Repository:  r,
+		Concurrency: *concurrencyFlag,
+		Checkpoint:  checkpoint,
+		Metadata:    !*noMetadataFlag,
+		Cache:       newBlobCache(repoPath),
 	}
 
-	s3Client, err := getS3Client(*profileFlag, *regionFlag)
+	store, err := newObjectStore(*backendFlag, *profileFlag, *regionFlag, *endpointFlag, *forcePathStyleFlag)
 	if err != nil {
 		return
 	}
 
-	rawObjects, err := queryS3Bucket(*bucketFlag, s3Client)
-	sortedVersions, err := queryS3Versions(rawObjects, *outputFlag, s3Client)
+	rawObjects, err := queryS3Bucket(*bucketFlag, store)
+	sortedVersions, err := queryS3Versions(rawObjects, *outputFlag, store)
 	if err != nil {
 		log.Println("Error getting versions of S3 Objects")
 		errorMessage(err)
 	}
-	replayS3Changes(sortedVersions, s3Client, repo)
+
+	switch *layoutFlag {
+	case "per-key-branches":
+		if err := replayPerKeyBranches(sortedVersions, store, repo); err != nil {
+			log.Println("Error replaying per-key branch history")
+			errorMessage(err)
+		}
+	case "linear":
+		replayS3Changes(sortedVersions, store, repo)
+	default:
+		errorMessage(fmt.Errorf("unsupported layout %q, expected one of: linear, per-key-branches", *layoutFlag))
+	}
 }
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// fakeStore is a minimal in-memory VersionedObjectStore, just enough to
+// exercise applyGitChanges and the per-key-branches layout without a real
+// S3 or GCS backend.
+type fakeStore struct {
+	versions map[string][]byte
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{versions: make(map[string][]byte)}
+}
+
+func (f *fakeStore) put(bucket, key, versionId string, body []byte) {
+	f.versions[fakeStoreID(bucket, key, versionId)] = body
+}
+
+func fakeStoreID(bucket, key, versionId string) string {
+	return bucket + "/" + key + "@" + versionId
+}
+
+func (f *fakeStore) ListObjects(bucket string) ([]S3Object, error) { return nil, nil }
+
+func (f *fakeStore) ListVersions(bucket, key string) ([]S3VersionedObject, error) { return nil, nil }
+
+func (f *fakeStore) GetObjectVersion(bucket, key, versionId string, withMetadata bool) (io.ReadCloser, *ObjectMetadata, error) {
+	body, ok := f.versions[fakeStoreID(bucket, key, versionId)]
+	if !ok {
+		return nil, nil, fmt.Errorf("fakeStore: no such version %s", fakeStoreID(bucket, key, versionId))
+	}
+	return io.NopCloser(bytes.NewReader(body)), &ObjectMetadata{VersionId: versionId}, nil
+}
+
+// newTestContainer creates a fresh git repo under a temp directory and
+// returns a gitContainer over it, along with the directory that
+// S3VersionedObject.RepositoryRoot should be set to so toLocalPath resolves
+// into the repo's worktree.
+func newTestContainer(t *testing.T, bucket string, withMetadata bool) (gitContainer, string) {
+	t.Helper()
+	repositoryRoot := t.TempDir()
+	repoPath := filepath.Join(repositoryRoot, bucket)
+	if err := os.MkdirAll(repoPath, 0777); err != nil {
+		t.Fatal(err)
+	}
+	r, err := git.PlainInit(repoPath, false)
+	if err != nil {
+		t.Fatalf("PlainInit: %v", err)
+	}
+	worktree, err := r.Worktree()
+	if err != nil {
+		t.Fatalf("Worktree: %v", err)
+	}
+	return gitContainer{
+		Tree:        worktree,
+		Repository:  r,
+		Concurrency: 1,
+		Metadata:    withMetadata,
+		Cache:       newBlobCache(repoPath),
+	}, repositoryRoot
+}
+
+func TestApplyGitChangesSkipsNoOpDeleteMarker(t *testing.T) {
+	const bucket = "bucket"
+	container, repositoryRoot := newTestContainer(t, bucket, false)
+	store := newFakeStore()
+
+	marker := S3VersionedObject{
+		Key:            "never-downloaded.txt",
+		Bucket:         bucket,
+		VersionId:      "v1",
+		RepositoryRoot: repositoryRoot,
+		LastModified:   time.Now(),
+		IsDeleteMarker: true,
+	}
+
+	if err := applyGitChanges([]S3VersionedObject{marker}, store, container); err != nil {
+		t.Fatalf("applyGitChanges returned an error for a no-op delete marker: %v", err)
+	}
+}
+
+func TestApplyGitChangesRemovesMetadataSidecarOnDeleteMarker(t *testing.T) {
+	const bucket = "bucket"
+	container, repositoryRoot := newTestContainer(t, bucket, true)
+	store := newFakeStore()
+	store.put(bucket, "c.txt", "v1", []byte("hello"))
+
+	add := S3VersionedObject{
+		Key:            "c.txt",
+		Bucket:         bucket,
+		VersionId:      "v1",
+		RepositoryRoot: repositoryRoot,
+		LastModified:   time.Now(),
+	}
+	if err := applyGitChanges([]S3VersionedObject{add}, store, container); err != nil {
+		t.Fatalf("applyGitChanges (add): %v", err)
+	}
+
+	localPath := add.toLocalPath()
+	if _, err := os.Stat(localPath + sidecarSuffix); err != nil {
+		t.Fatalf("expected metadata sidecar to exist after add: %v", err)
+	}
+
+	remove := S3VersionedObject{
+		Key:            "c.txt",
+		Bucket:         bucket,
+		VersionId:      "v2",
+		RepositoryRoot: repositoryRoot,
+		LastModified:   add.LastModified.Add(time.Second),
+		IsDeleteMarker: true,
+	}
+	if err := applyGitChanges([]S3VersionedObject{remove}, store, container); err != nil {
+		t.Fatalf("applyGitChanges (delete marker): %v", err)
+	}
+
+	if _, err := os.Stat(localPath); !os.IsNotExist(err) {
+		t.Errorf("expected %s to be removed, stat err = %v", localPath, err)
+	}
+	if _, err := os.Stat(localPath + sidecarSuffix); !os.IsNotExist(err) {
+		t.Errorf("expected metadata sidecar %s to be removed, stat err = %v", localPath+sidecarSuffix, err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// S3Store implements VersionedObjectStore against AWS S3, or any
+// S3-compatible endpoint such as MinIO or Ceph when constructed with a
+// custom endpoint and path-style addressing.
+type S3Store struct {
+	client *s3.Client
+}
+
+func newS3Store(profile, region, endpoint string, forcePathStyle bool) (*S3Store, error) {
+	var sdkConfig aws.Config
+	var err error
+	if profile != "" {
+		sdkConfig, err = config.LoadDefaultConfig(context.TODO(), config.WithSharedConfigProfile(profile), config.WithRegion(region))
+	} else {
+		sdkConfig, err = config.LoadDefaultConfig(context.TODO(), config.WithRegion(region))
+	}
+	if err != nil {
+		log.Println("Couldn't load default configuration. Have you set up your AWS account?")
+		errorMessage(err)
+		return nil, err
+	}
+
+	client := s3.NewFromConfig(sdkConfig, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+		}
+		o.UsePathStyle = forcePathStyle
+	})
+	return &S3Store{client: client}, nil
+}
+
+func (s *S3Store) ListObjects(bucket string) ([]S3Object, error) {
+	rawObjects := make([]S3Object, 0)
+	var continuationToken *string
+	for {
+		result, err := s.client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{
+			Bucket:            aws.String(bucket),
+			ContinuationToken: continuationToken,
+		})
+		if err != nil {
+			log.Printf("ListObjectsV2 failed while querying: %s\n", bucket)
+			errorMessage(err)
+			return nil, err
+		}
+		for _, s3obj := range result.Contents {
+			rawObjects = append(rawObjects, S3Object{
+				Key:    aws.ToString(s3obj.Key),
+				Bucket: bucket,
+			})
+		}
+		if !aws.ToBool(result.IsTruncated) {
+			break
+		}
+		continuationToken = result.NextContinuationToken
+	}
+	return rawObjects, nil
+}
+
+func (s *S3Store) ListVersions(bucket, key string) ([]S3VersionedObject, error) {
+	versions := make([]S3VersionedObject, 0)
+	var keyMarker *string
+	var versionIdMarker *string
+	for {
+		result, err := s.client.ListObjectVersions(context.TODO(), &s3.ListObjectVersionsInput{
+			Bucket:          aws.String(bucket),
+			Prefix:          aws.String(key),
+			KeyMarker:       keyMarker,
+			VersionIdMarker: versionIdMarker,
+		})
+		if err != nil {
+			log.Println("Couldn't load default configuration. Have you set up your AWS account?")
+			errorMessage(err)
+			return versions, err
+		}
+		for _, vers := range result.Versions {
+			if !isExactKey(aws.ToString(vers.Key), key) {
+				continue
+			}
+			versions = append(versions, S3VersionedObject{
+				Key:          aws.ToString(vers.Key),
+				Bucket:       bucket,
+				VersionId:    aws.ToString(vers.VersionId),
+				LastModified: aws.ToTime(vers.LastModified),
+			})
+		}
+		for _, marker := range result.DeleteMarkers {
+			if !isExactKey(aws.ToString(marker.Key), key) {
+				continue
+			}
+			versions = append(versions, S3VersionedObject{
+				Key:            aws.ToString(marker.Key),
+				Bucket:         bucket,
+				VersionId:      aws.ToString(marker.VersionId),
+				LastModified:   aws.ToTime(marker.LastModified),
+				IsDeleteMarker: true,
+			})
+		}
+		if !aws.ToBool(result.IsTruncated) {
+			break
+		}
+		keyMarker = result.NextKeyMarker
+		versionIdMarker = result.NextVersionIdMarker
+	}
+	return versions, nil
+}
+
+func (s *S3Store) GetObjectVersion(bucket, key, versionId string, withMetadata bool) (io.ReadCloser, *ObjectMetadata, error) {
+	result, err := s.client.GetObject(context.TODO(), &s3.GetObjectInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: aws.String(versionId),
+	})
+	if err != nil {
+		log.Printf("Couldn't get object %v:%v@%v.\n", bucket, key, versionId)
+		errorMessage(err)
+		return nil, nil, err
+	}
+
+	metadata := &ObjectMetadata{
+		VersionId:    versionId,
+		ETag:         strings.Trim(aws.ToString(result.ETag), "\""),
+		ContentType:  aws.ToString(result.ContentType),
+		StorageClass: string(result.StorageClass),
+		UserMetadata: result.Metadata,
+		Tags:         make(map[string]string),
+	}
+
+	if !withMetadata {
+		return result.Body, metadata, nil
+	}
+
+	tagging, err := s.client.GetObjectTagging(context.TODO(), &s3.GetObjectTaggingInput{
+		Bucket:    aws.String(bucket),
+		Key:       aws.String(key),
+		VersionId: aws.String(versionId),
+	})
+	if err != nil {
+		log.Printf("Couldn't get object tags for %v:%v@%v.\n", bucket, key, versionId)
+		errorMessage(err)
+	} else {
+		for _, tag := range tagging.TagSet {
+			metadata.Tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+	}
+
+	return result.Body, metadata, nil
+}
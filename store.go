@@ -0,0 +1,36 @@
+package main
+
+import "io"
+
+// ObjectMetadata carries the S3 user metadata, tags, and object attributes
+// associated with a single object version, so callers can preserve them
+// outside of the raw object bytes.
+type ObjectMetadata struct {
+	VersionId    string
+	ETag         string
+	ContentType  string
+	StorageClass string
+	UserMetadata map[string]string
+	Tags         map[string]string
+}
+
+// VersionedObjectStore abstracts the versioned-bucket operations the tool
+// needs so it can convert any backend that keeps a full version history for
+// an object, not just AWS S3.
+type VersionedObjectStore interface {
+	ListObjects(bucket string) ([]S3Object, error)
+	ListVersions(bucket, key string) ([]S3VersionedObject, error)
+	// GetObjectVersion fetches a version's bytes and metadata. withMetadata
+	// is false when the caller only wants the bytes (--no-metadata), so
+	// implementations should skip any extra backend calls needed only for
+	// data that ends up in the sidecar/trailers, such as S3 object tagging.
+	GetObjectVersion(bucket, key, versionId string, withMetadata bool) (io.ReadCloser, *ObjectMetadata, error)
+}
+
+// isExactKey reports whether a listing result's key is an exact match for
+// the requested key, rather than merely sharing it as a prefix. Both backends
+// list versions by prefix, which also matches sibling keys like
+// "<key>.bak" or "<key>-old" that the caller never asked for.
+func isExactKey(gotKey, wantKey string) bool {
+	return gotKey == wantKey
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func TestIsExactKeyMatchesOnlyTheExactKey(t *testing.T) {
+	cases := []struct {
+		gotKey, wantKey string
+		want            bool
+	}{
+		{"foo.txt", "foo.txt", true},
+		{"foo.txt.bak", "foo.txt", false},
+		{"foo.txt-old", "foo.txt", false},
+		{"foo.txt", "foo.txt.bak", false},
+	}
+	for _, c := range cases {
+		if got := isExactKey(c.gotKey, c.wantKey); got != c.want {
+			t.Errorf("isExactKey(%q, %q) = %v, want %v", c.gotKey, c.wantKey, got, c.want)
+		}
+	}
+}